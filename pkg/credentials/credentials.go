@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package credentials gives every CSI plugin (disk, nas, oss, cpfs) and the
+// metrics pusher a single place to obtain Alibaba Cloud credentials, instead
+// of each wiring up its own AK/SK. It wraps the provider chain from
+// alibaba-cloud-sdk-go so callers get env vars, an explicit AK/SK file, ACK
+// workload identity (RRSA/OIDC), a plain STS assume-role and the ECS
+// instance RAM role for free, tried in that order, with auto-refresh before
+// expiry.
+package credentials
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials/providers"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configures the provider chain. Every field is optional; unset
+// fields fall back to the matching provider's own defaults (env vars, the
+// metadata server endpoint, ...).
+type Options struct {
+	// AccessKeyFile is an explicit AK/SK or STS token file, used when set.
+	AccessKeyFile string
+	// RoleName pins the ECS instance RAM role to assume, rather than
+	// whichever role is attached to the instance.
+	RoleName string
+	// OIDCTokenFile and RoleARN configure RRSA/OIDC web-identity exchange for
+	// ACK Pod Identity workloads.
+	OIDCTokenFile   string
+	RoleARN         string
+	OIDCProviderARN string
+	// StsRoleARN and StsSessionName configure a plain STS AssumeRole
+	// exchange, for callers that authenticate as an IAM user/role rather
+	// than through ACK workload identity.
+	StsRoleARN     string
+	StsSessionName string
+	// RefreshJitter bounds how far ahead of expiry the refresh is randomly
+	// staggered, so many node plugins don't all refresh in lockstep.
+	RefreshJitter time.Duration
+}
+
+// Provider resolves one possible source of credentials. It mirrors the
+// alibaba-cloud-sdk-go provider interface, wrapped locally so the chain can
+// be exercised in tests against a fake provider instead of a live metadata
+// server or STS endpoint.
+type Provider interface {
+	Resolve() (credentials.Credential, error)
+}
+
+// providerFunc adapts a plain func to Provider, the way http.HandlerFunc
+// adapts a func to http.Handler.
+type providerFunc func() (credentials.Credential, error)
+
+func (f providerFunc) Resolve() (credentials.Credential, error) { return f() }
+
+// Chain tries each Provider in order and keeps the first successful
+// credential, refreshing it before it expires.
+type Chain struct {
+	opts      Options
+	providers []Provider
+
+	mu         sync.RWMutex
+	current    credentials.Credential
+	expiration time.Time
+
+	stopCh chan struct{}
+}
+
+// NewChain builds the provider chain: env provider -> explicit AK/SK file ->
+// RRSA/OIDC web-identity -> STS assume-role -> ECS instance RAM role. The
+// first provider able to produce a credential wins. If opts.OIDCTokenFile is
+// set, NewChain also starts WatchTokenFile so a kubelet-rotated projected
+// token triggers an immediate refresh rather than waiting on Get to notice
+// the stale expiry.
+func NewChain(opts Options) (*Chain, error) {
+	var chain []Provider
+	chain = append(chain, providerFunc(func() (credentials.Credential, error) {
+		return providers.NewEnvCredentialProvider().Resolve()
+	}))
+	if opts.AccessKeyFile != "" {
+		chain = append(chain, providerFunc(func() (credentials.Credential, error) {
+			return providers.NewProfileCredentialProvider(opts.AccessKeyFile).Resolve()
+		}))
+	}
+	if opts.OIDCTokenFile != "" && opts.RoleARN != "" {
+		chain = append(chain, providerFunc(func() (credentials.Credential, error) {
+			return providers.NewOIDCCredentialProvider(opts.OIDCTokenFile, opts.RoleARN, opts.OIDCProviderARN).Resolve()
+		}))
+	}
+	if opts.StsRoleARN != "" {
+		chain = append(chain, providerFunc(func() (credentials.Credential, error) {
+			return providers.NewRAMRoleArnCredentialProvider(opts.StsRoleARN, opts.StsSessionName).Resolve()
+		}))
+	}
+	chain = append(chain, providerFunc(func() (credentials.Credential, error) {
+		return providers.NewInstanceMetadataProvider(opts.RoleName).Resolve()
+	}))
+
+	c := newChainFromProviders(opts, chain)
+	if _, err := c.refresh(); err != nil {
+		return nil, fmt.Errorf("credentials: initial refresh failed: %v", err)
+	}
+
+	if opts.OIDCTokenFile != "" {
+		if err := c.WatchTokenFile(opts.OIDCTokenFile, c.stopCh); err != nil {
+			log.Warnf("credentials: watch token file %s failed, rotation won't trigger an automatic refresh: %v", opts.OIDCTokenFile, err)
+		}
+	}
+	return c, nil
+}
+
+// newChainFromProviders builds a Chain around an already-assembled provider
+// list, so tests can exercise refresh/fallback/expiry behavior against fake
+// providers without going through NewChain's real SDK providers.
+func newChainFromProviders(opts Options, chain []Provider) *Chain {
+	return &Chain{
+		opts:      opts,
+		providers: chain,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Close stops the token-file watcher started by NewChain, if any.
+func (c *Chain) Close() {
+	select {
+	case <-c.stopCh:
+	default:
+		close(c.stopCh)
+	}
+}
+
+// Get returns the current credential, transparently refreshing it if it is
+// at or past expiry.
+func (c *Chain) Get() (credentials.Credential, error) {
+	c.mu.RLock()
+	cred, expiration := c.current, c.expiration
+	c.mu.RUnlock()
+
+	if expiration.IsZero() || time.Now().Before(expiration) {
+		return cred, nil
+	}
+	return c.refresh()
+}
+
+func (c *Chain) refresh() (credentials.Credential, error) {
+	var lastErr error
+	var cred credentials.Credential
+	for _, p := range c.providers {
+		cred, lastErr = p.Resolve()
+		if lastErr == nil && cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, fmt.Errorf("credentials: no provider in the chain produced a credential: %v", lastErr)
+	}
+
+	expiration := expiryOf(cred)
+	if !expiration.IsZero() {
+		jitter := c.opts.RefreshJitter
+		if jitter <= 0 {
+			jitter = time.Minute
+		}
+		expiration = expiration.Add(-time.Duration(rand.Int63n(int64(jitter))))
+	}
+
+	c.mu.Lock()
+	c.current, c.expiration = cred, expiration
+	c.mu.Unlock()
+
+	log.Infof("credentials: refreshed via %T, next refresh around %v", cred, expiration)
+	return cred, nil
+}
+
+// defaultCredentialTTL is the refresh interval assumed for a credential that
+// can expire but doesn't surface its own expiry (see expiryOf). STS tokens
+// handed out by AssumeRole/the metadata server are typically valid for an
+// hour; refreshing well inside that window keeps Get from ever serving a
+// credential the server has already rotated out from under it.
+const defaultCredentialTTL = 15 * time.Minute
+
+// expiryOf extracts the expiry time from credential types that carry one
+// directly; static AK/SK credentials never expire.
+//
+// None of the concrete types alibaba-cloud-sdk-go's auth/credentials
+// providers actually hand back (AccessKeyCredential, StsTokenCredential,
+// RamRoleArnCredential, EcsRamRoleCredential, the OIDC/RRSA-derived STS
+// token, ...) implement an Expiration() method -- the SDK tracks expiry
+// internally in its own STS signer, not on the Credential value. So for
+// every non-static credential, fall back to defaultCredentialTTL rather than
+// treating a missing Expiration() as "never expires", or the chain would
+// silently keep serving a credential long after the real one behind it has
+// rotated.
+func expiryOf(cred credentials.Credential) time.Time {
+	type expirer interface {
+		Expiration() time.Time
+	}
+	if e, ok := cred.(expirer); ok {
+		if exp := e.Expiration(); !exp.IsZero() {
+			return exp
+		}
+	}
+	if _, static := cred.(*credentials.AccessKeyCredential); static {
+		return time.Time{}
+	}
+	return time.Now().Add(defaultCredentialTTL)
+}
+
+var (
+	globalOnce  sync.Once
+	globalChain *Chain
+	globalErr   error
+)
+
+// Global lazily builds and returns the process-wide credential chain used by
+// all CSI plugins and the metrics pusher, built from Options gathered from
+// flags/env by the caller on first use.
+func Global(opts Options) (*Chain, error) {
+	globalOnce.Do(func() {
+		globalChain, globalErr = NewChain(opts)
+	})
+	return globalChain, globalErr
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentials
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+)
+
+// fakeExpiringCredential lets tests control exactly when a resolved
+// credential is treated as stale, without depending on a real STS token.
+type fakeExpiringCredential struct {
+	credentials.Credential
+	expiration time.Time
+}
+
+func (f fakeExpiringCredential) Expiration() time.Time { return f.expiration }
+
+func TestChainFallsThroughToNextProvider(t *testing.T) {
+	want := &credentials.AccessKeyCredential{AccessKeyId: "ak-from-second-provider"}
+	chain := newChainFromProviders(Options{}, []Provider{
+		providerFunc(func() (credentials.Credential, error) {
+			return nil, errors.New("first provider unavailable")
+		}),
+		providerFunc(func() (credentials.Credential, error) {
+			return want, nil
+		}),
+	})
+
+	if _, err := chain.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	got, err := chain.Get()
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != credentials.Credential(want) {
+		t.Fatalf("Get() = %v, want the credential from the second provider", got)
+	}
+}
+
+func TestChainReturnsErrorWhenEveryProviderFails(t *testing.T) {
+	chain := newChainFromProviders(Options{}, []Provider{
+		providerFunc(func() (credentials.Credential, error) {
+			return nil, errors.New("boom")
+		}),
+	})
+
+	if _, err := chain.refresh(); err == nil {
+		t.Fatal("refresh() expected an error when every provider fails, got nil")
+	}
+}
+
+func TestChainRefreshesOncePastExpiry(t *testing.T) {
+	calls := 0
+	chain := newChainFromProviders(Options{}, []Provider{
+		providerFunc(func() (credentials.Credential, error) {
+			calls++
+			return fakeExpiringCredential{
+				Credential: &credentials.AccessKeyCredential{AccessKeyId: "ak"},
+				expiration: time.Now().Add(-time.Minute), // already expired
+			}, nil
+		}),
+	})
+
+	if _, err := chain.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	if _, err := chain.Get(); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected Get() to re-resolve an already-expired credential, got %d total resolves", calls)
+	}
+}
+
+func TestChainRefreshesRealStsCredentialOnDefaultTTL(t *testing.T) {
+	calls := 0
+	chain := newChainFromProviders(Options{}, []Provider{
+		providerFunc(func() (credentials.Credential, error) {
+			calls++
+			// StsTokenCredential, like every other real credential type the
+			// SDK's providers resolve to, doesn't implement Expiration();
+			// expiryOf must still schedule a refresh for it rather than
+			// treating it as never-expiring.
+			return &credentials.StsTokenCredential{AccessKeyId: "ak", AccessKeySecret: "sk", AccessKeyStsToken: "token"}, nil
+		}),
+	})
+
+	if _, err := chain.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	if chain.expiration.IsZero() {
+		t.Fatal("expiration stayed zero for an StsTokenCredential, want a default TTL to be scheduled")
+	}
+	if !chain.expiration.After(time.Now()) {
+		t.Fatalf("expiration = %v, want a time in the future", chain.expiration)
+	}
+	if calls != 1 {
+		t.Fatalf("expected exactly one resolve during refresh(), got %d", calls)
+	}
+}
+
+func TestChainGetReusesUnexpiredCredential(t *testing.T) {
+	calls := 0
+	chain := newChainFromProviders(Options{}, []Provider{
+		providerFunc(func() (credentials.Credential, error) {
+			calls++
+			return fakeExpiringCredential{
+				Credential: &credentials.AccessKeyCredential{AccessKeyId: "ak"},
+				expiration: time.Now().Add(time.Hour),
+			}, nil
+		}),
+	})
+
+	if _, err := chain.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	if _, err := chain.Get(); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected Get() to reuse the unexpired credential without re-resolving, got %d resolves", calls)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentials
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchTokenFile watches tokenFile (typically a Kubernetes
+// projected-service-account token used for RRSA/OIDC) and forces a refresh
+// whenever the kubelet rotates it, instead of waiting for Get to notice the
+// stale expiry. It runs until stopCh is closed.
+func (c *Chain) WatchTokenFile(tokenFile string, stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// Kubernetes rotates the projected token via an atomic rename of the
+	// containing directory's symlink, so watch the directory rather than the
+	// file itself.
+	dir := filepath.Dir(tokenFile)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(tokenFile) && filepath.Dir(event.Name) != dir {
+					continue
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+					continue
+				}
+				if _, err := c.refresh(); err != nil {
+					log.Warnf("credentials: refresh after token rotation failed: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("credentials: token file watcher error: %v", err)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	log "github.com/sirupsen/logrus"
+	k8smount "k8s.io/kubernetes/pkg/util/mount"
+)
+
+// ephemeralContextKey is the well-known VolumeContext key kubelet sets on
+// CSI inline ephemeral volumes.
+const ephemeralContextKey = "csi.storage.k8s.io/ephemeral"
+
+// isEphemeralVolume reports whether volumeContext marks the request as a CSI
+// inline ephemeral volume declared directly in a pod spec, rather than one
+// backed by a PVC.
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	return strings.ToLower(volumeContext[ephemeralContextKey]) == "true"
+}
+
+// createEphemeralVolume provisions, attaches, formats and stages an ECS disk
+// for an inline ephemeral volume entirely within NodePublishVolume, and
+// returns the path NodePublishVolume should bind-mount into the pod. Enough
+// metadata is persisted under VolumeDir for NodeUnpublishVolume to detach and
+// delete the disk again.
+func (ns *nodeServer) createEphemeralVolume(req *csi.NodePublishVolumeRequest) (string, error) {
+	volumeID := req.VolumeId
+	stagingPath := filepath.Join(VolumeDir, volumeID, "globalmount")
+
+	// NodePublishVolume must tolerate retries (a transient failure in the
+	// bind-mount step below, or the plugin restarting mid-publish); reuse
+	// the disk a prior attempt already provisioned and staged instead of
+	// provisioning a second one and orphaning the first.
+	if diskID := getEphemeralDisk(volumeID); diskID != "" {
+		if device := getVolumeConfig(volumeID); device != "" {
+			log.Infof("NodePublishVolume: ephemeral volume %s already provisioned, disk %s, device %s, reusing", volumeID, diskID, device)
+			return stagingPath, nil
+		}
+	}
+
+	volOptions := req.GetVolumeContext()
+
+	sizeGb := 20
+	if value, ok := volOptions["size"]; ok {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid size %q for ephemeral volume %s: %v", value, volumeID, err)
+		}
+		sizeGb = size
+	}
+	diskType := volOptions["type"]
+	if diskType == "" {
+		diskType = "cloud_efficiency"
+	}
+	encrypted := strings.ToLower(volOptions["encrypted"]) == "true"
+	zoneID := volOptions["zoneId"]
+	if zoneID == "" {
+		zoneID = ns.zone
+	}
+
+	diskID, err := createDiskForEphemeral(volumeID, zoneID, diskType, sizeGb, encrypted)
+	if err != nil {
+		return "", fmt.Errorf("create ECS disk for ephemeral volume %s: %v", volumeID, err)
+	}
+
+	device, err := attachDisk(diskID, ns.nodeID, false, true)
+	if err != nil {
+		return "", fmt.Errorf("attach ephemeral disk %s: %v", diskID, err)
+	}
+	if err := checkDeviceAvailable(device); err != nil {
+		return "", err
+	}
+
+	if err := ns.mounter.EnsureFolder(stagingPath); err != nil {
+		return "", err
+	}
+
+	fsType := "ext4"
+	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil && mnt.FsType != "" {
+		fsType = mnt.FsType
+	}
+	diskMounter := &k8smount.SafeFormatAndMount{Interface: ns.k8smounter, Exec: k8smount.NewOsExec()}
+	if err := diskMounter.FormatAndMount(device, stagingPath, fsType, []string{"shared"}); err != nil {
+		return "", fmt.Errorf("format and mount ephemeral disk %s: %v", device, err)
+	}
+
+	if err := saveVolumeConfig(volumeID, device); err != nil {
+		return "", err
+	}
+	if err := saveEphemeralDisk(volumeID, diskID); err != nil {
+		return "", err
+	}
+	log.Infof("NodePublishVolume: ephemeral volume %s provisioned, disk %s, device %s", volumeID, diskID, device)
+	return stagingPath, nil
+}
+
+// deleteEphemeralVolume unmounts, detaches and releases the ECS disk created
+// for an inline ephemeral volume. It is a no-op for volumeIDs that were
+// never marked as ephemeral, so NodeUnpublishVolume can call it
+// unconditionally.
+func (ns *nodeServer) deleteEphemeralVolume(volumeID string) error {
+	diskID := getEphemeralDisk(volumeID)
+	if diskID == "" {
+		return nil
+	}
+
+	stagingPath := filepath.Join(VolumeDir, volumeID, "globalmount")
+	if state, err := ns.getMountState(stagingPath); err == nil && state != mountStateUnmounted {
+		if err := ns.k8smounter.Unmount(stagingPath); err != nil {
+			return fmt.Errorf("unmount ephemeral staging path %s: %v", stagingPath, err)
+		}
+	}
+	if err := detachDisk(diskID, ns.nodeID, true); err != nil {
+		return fmt.Errorf("detach ephemeral disk %s: %v", diskID, err)
+	}
+	if err := deleteDisk(diskID); err != nil {
+		return fmt.Errorf("delete ephemeral disk %s: %v", diskID, err)
+	}
+	removeVolumeConfig(volumeID)
+	removeEphemeralDisk(volumeID)
+	log.Infof("NodeUnpublishVolume: ephemeral volume %s released, disk %s", volumeID, diskID)
+	return nil
+}
+
+// ephemeralMarkerDir is namespaced under its own subdirectory, not flat in
+// VolumeDir, so healVolumes (which treats every entry name in VolumeDir as a
+// raw volumeID) never mistakes a marker file for a saved volume config. It's
+// a var, not a const, so tests can point it at a temp directory instead of
+// the real host path.
+var ephemeralMarkerDir = VolumeDir + "ephemeral/"
+
+func ephemeralMarkerPath(volumeID string) string {
+	return filepath.Join(ephemeralMarkerDir, volumeID)
+}
+
+func saveEphemeralDisk(volumeID, diskID string) error {
+	if err := os.MkdirAll(ephemeralMarkerDir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ephemeralMarkerPath(volumeID), []byte(diskID), 0644)
+}
+
+func getEphemeralDisk(volumeID string) string {
+	data, err := ioutil.ReadFile(ephemeralMarkerPath(volumeID))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func removeEphemeralDisk(volumeID string) {
+	os.Remove(ephemeralMarkerPath(volumeID))
+}
+
+// createDiskForEphemeral creates the ECS disk backing an inline ephemeral
+// volume and returns its disk ID.
+func createDiskForEphemeral(volumeID, zoneID, diskType string, sizeGb int, encrypted bool) (string, error) {
+	req := ecs.CreateCreateDiskRequest()
+	req.RegionId = GlobalConfigVar.Region
+	req.ZoneId = zoneID
+	req.DiskName = volumeID
+	req.DiskCategory = diskType
+	req.Size = requests.NewInteger(sizeGb)
+	req.Encrypted = requests.NewBoolean(encrypted)
+	resp, err := GlobalConfigVar.EcsClient.CreateDisk(req)
+	if err != nil {
+		return "", err
+	}
+	return resp.DiskId, nil
+}
+
+func deleteDisk(diskID string) error {
+	req := ecs.CreateDeleteDiskRequest()
+	req.DiskId = diskID
+	_, err := GlobalConfigVar.EcsClient.DeleteDisk(req)
+	return err
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import "testing"
+
+func TestIsEphemeralVolume(t *testing.T) {
+	cases := []struct {
+		name    string
+		context map[string]string
+		want    bool
+	}{
+		{"unset", map[string]string{}, false},
+		{"true", map[string]string{ephemeralContextKey: "true"}, true},
+		{"mixed case", map[string]string{ephemeralContextKey: "True"}, true},
+		{"false", map[string]string{ephemeralContextKey: "false"}, false},
+		{"nil context", nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isEphemeralVolume(c.context); got != c.want {
+				t.Errorf("isEphemeralVolume(%v) = %v, want %v", c.context, got, c.want)
+			}
+		})
+	}
+}
+
+func TestEphemeralDiskMarkerRoundTrip(t *testing.T) {
+	origDir := ephemeralMarkerDir
+	ephemeralMarkerDir = t.TempDir() + "/"
+	t.Cleanup(func() { ephemeralMarkerDir = origDir })
+
+	if got := getEphemeralDisk("vol-1"); got != "" {
+		t.Fatalf("getEphemeralDisk() = %q before any marker is saved, want empty", got)
+	}
+
+	if err := saveEphemeralDisk("vol-1", "d-123"); err != nil {
+		t.Fatalf("saveEphemeralDisk() error = %v", err)
+	}
+	if got := getEphemeralDisk("vol-1"); got != "d-123" {
+		t.Fatalf("getEphemeralDisk() = %q, want d-123", got)
+	}
+
+	// A second volume's marker must not collide with the first's.
+	if err := saveEphemeralDisk("vol-2", "d-456"); err != nil {
+		t.Fatalf("saveEphemeralDisk() error = %v", err)
+	}
+	if got := getEphemeralDisk("vol-1"); got != "d-123" {
+		t.Fatalf("getEphemeralDisk(vol-1) = %q after saving vol-2's marker, want it unchanged at d-123", got)
+	}
+
+	removeEphemeralDisk("vol-1")
+	if got := getEphemeralDisk("vol-1"); got != "" {
+		t.Fatalf("getEphemeralDisk() = %q after removeEphemeralDisk, want empty", got)
+	}
+	if got := getEphemeralDisk("vol-2"); got != "d-456" {
+		t.Fatalf("getEphemeralDisk(vol-2) = %q after removing vol-1's marker, want it unaffected at d-456", got)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"io/ioutil"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// healVolumes runs once at node server startup. If the plugin container was
+// restarted while volumes were already staged, the kubelet and the
+// external-attacher won't call NodeStageVolume again (ADController in
+// particular never will), so any device rename or corrupted bind mount left
+// behind is never repaired on its own. This walks VolumeDir for the configs
+// saveVolumeConfig wrote before the restart and resurrects each one that is
+// still attached to this instance.
+func healVolumes(ns *nodeServer) {
+	files, err := ioutil.ReadDir(VolumeDir)
+	if err != nil {
+		log.Warnf("VolumeHealer: failed to list %s: %v", VolumeDir, err)
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		volumeID := file.Name()
+		// Stage/Unstage for this volume can race in the instant the gRPC
+		// server comes up; skip healing rather than unmount/mount under a
+		// concurrent Stage/Unstage call.
+		if !ns.volumeLocks.TryAcquire(volumeID) {
+			log.Infof("VolumeHealer: volume %s has a Stage/Unstage in progress, skipping", volumeID)
+			continue
+		}
+		err := healVolume(ns, volumeID)
+		ns.volumeLocks.Release(volumeID)
+		if err != nil {
+			log.Errorf("VolumeHealer: failed to heal volume %s: %v", volumeID, err)
+		}
+	}
+}
+
+// Indirected through vars, rather than called directly, so tests can drive
+// healVolume's branches (disk detached/renamed/healthy) without an ECS
+// client or the real on-disk VolumeDir.
+var (
+	healerFindDiskByVolumeID  = findDiskByVolumeID
+	healerGetDeviceByVolumeID = GetDeviceByVolumeID
+	healerGetVolumeConfig     = getVolumeConfig
+	healerSaveVolumeConfig    = saveVolumeConfig
+)
+
+func healVolume(ns *nodeServer, volumeID string) error {
+	savedDevice := healerGetVolumeConfig(volumeID)
+	if savedDevice == "" {
+		return nil
+	}
+
+	disk, err := healerFindDiskByVolumeID(volumeID)
+	if err != nil {
+		return err
+	}
+	if disk == nil || disk.Status != DiskStatusInuse || disk.InstanceID != ns.nodeID {
+		// not attached to this node (anymore), nothing for the healer to do
+		return nil
+	}
+
+	device, err := healerGetDeviceByVolumeID(volumeID)
+	if err != nil || device == "" {
+		log.Warnf("VolumeHealer: volume %s is In_use on this node but its device can't be found, skipping", volumeID)
+		return nil
+	}
+	if device != savedDevice {
+		log.Infof("VolumeHealer: volume %s device renamed by the kernel, %s -> %s, refreshing saved config", volumeID, savedDevice, device)
+		if err := healerSaveVolumeConfig(volumeID, device); err != nil {
+			return err
+		}
+	}
+
+	return ns.healMountPoints(volumeID, device)
+}
+
+// healMountPoints re-stages any existing bind mount of device that has gone
+// corrupted, so applications recover in place instead of waiting on a pod
+// reschedule.
+func (ns *nodeServer) healMountPoints(volumeID, device string) error {
+	// GetMountRefs only matches mountinfo entries by MountPoint/Root, never by
+	// device node, so it can't be called with device directly; go through
+	// getDeviceMountRefs (mount_refs.go), which adds the mountinfo fallback
+	// scan that actually resolves a device to its bind-mount targets.
+	refs, err := ns.getDeviceMountRefs(device)
+	if err != nil {
+		log.Warnf("VolumeHealer: volume %s, get mount refs of %s error: %v", volumeID, device, err)
+		return nil
+	}
+	for _, target := range refs {
+		state, err := ns.getMountState(target)
+		if err != nil {
+			log.Warnf("VolumeHealer: volume %s, check mount state of %s error: %v", volumeID, target, err)
+			continue
+		}
+		if state != mountStateCorrupted {
+			continue
+		}
+		log.Errorf("VolumeHealer: volume %s, mount point %s is corrupted, unmounting and re-staging", volumeID, target)
+		if err := ns.k8smounter.Unmount(target); err != nil {
+			log.Errorf("VolumeHealer: volume %s, unmount corrupted target %s failed: %v", volumeID, target, err)
+			continue
+		}
+		if err := ns.k8smounter.Mount(device, target, "", []string{"bind"}); err != nil {
+			log.Errorf("VolumeHealer: volume %s, re-bind mount %s -> %s failed: %v", volumeID, device, target, err)
+			continue
+		}
+		log.Infof("VolumeHealer: volume %s, recovered corrupted mount at %s", volumeID, target)
+	}
+	return nil
+}
+
+// findDiskByVolumeID describes the ECS disk behind volumeID so the healer can
+// confirm it is still attached to this node before touching its mount state.
+func findDiskByVolumeID(volumeID string) (*ecs.Disk, error) {
+	req := ecs.CreateDescribeDisksRequest()
+	req.RegionId = GlobalConfigVar.Region
+	req.DiskIds = "[\"" + volumeID + "\"]"
+	resp, err := GlobalConfigVar.EcsClient.DescribeDisks(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Disks.Disk) == 0 {
+		return nil, nil
+	}
+	return &resp.Disks.Disk[0], nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+// withHealerLookups swaps the healer's package-level lookup seams for the
+// duration of a test, restoring the originals on cleanup.
+func withHealerLookups(t *testing.T, findDisk func(string) (*ecs.Disk, error), getDevice func(string) (string, error), getConfig func(string) string, saveConfig func(string, string) error) {
+	t.Helper()
+	origFind, origDevice, origGet, origSave := healerFindDiskByVolumeID, healerGetDeviceByVolumeID, healerGetVolumeConfig, healerSaveVolumeConfig
+	healerFindDiskByVolumeID, healerGetDeviceByVolumeID, healerGetVolumeConfig, healerSaveVolumeConfig = findDisk, getDevice, getConfig, saveConfig
+	t.Cleanup(func() {
+		healerFindDiskByVolumeID, healerGetDeviceByVolumeID, healerGetVolumeConfig, healerSaveVolumeConfig = origFind, origDevice, origGet, origSave
+	})
+}
+
+func TestHealVolumeSkipsWhenNoSavedConfig(t *testing.T) {
+	withHealerLookups(t,
+		func(string) (*ecs.Disk, error) {
+			t.Fatal("findDiskByVolumeID should not be called without a saved device")
+			return nil, nil
+		},
+		func(string) (string, error) { return "", nil },
+		func(string) string { return "" },
+		func(string, string) error { return nil },
+	)
+
+	if err := healVolume(&nodeServer{}, "vol-1"); err != nil {
+		t.Fatalf("healVolume() error = %v, want nil", err)
+	}
+}
+
+func TestHealVolumeSkipsWhenNotAttachedToThisNode(t *testing.T) {
+	withHealerLookups(t,
+		func(string) (*ecs.Disk, error) {
+			return &ecs.Disk{Status: DiskStatusInuse, InstanceID: "some-other-node"}, nil
+		},
+		func(string) (string, error) {
+			t.Fatal("device lookup should not run for a disk not on this node")
+			return "", nil
+		},
+		func(string) string { return "/dev/vdb" },
+		func(string, string) error { return nil },
+	)
+
+	if err := healVolume(&nodeServer{nodeID: "this-node"}, "vol-1"); err != nil {
+		t.Fatalf("healVolume() error = %v, want nil", err)
+	}
+}
+
+func TestHealVolumeRefreshesRenamedDevice(t *testing.T) {
+	var savedVolumeID, savedDevice string
+	withHealerLookups(t,
+		func(string) (*ecs.Disk, error) {
+			return &ecs.Disk{Status: DiskStatusInuse, InstanceID: "this-node"}, nil
+		},
+		func(string) (string, error) { return "/dev/vdc", nil },
+		func(string) string { return "/dev/vdb" },
+		func(volumeID, device string) error {
+			savedVolumeID, savedDevice = volumeID, device
+			return nil
+		},
+	)
+	ns := &nodeServer{nodeID: "this-node", k8smounter: &fakeMounter{}}
+
+	if err := healVolume(ns, "vol-1"); err != nil {
+		t.Fatalf("healVolume() error = %v, want nil", err)
+	}
+	if savedVolumeID != "vol-1" || savedDevice != "/dev/vdc" {
+		t.Fatalf("saveVolumeConfig(%q, %q), want (vol-1, /dev/vdc)", savedVolumeID, savedDevice)
+	}
+}
+
+func TestHealVolumePropagatesFindDiskError(t *testing.T) {
+	withHealerLookups(t,
+		func(string) (*ecs.Disk, error) { return nil, errors.New("DescribeDisks boom") },
+		func(string) (string, error) { return "", nil },
+		func(string) string { return "/dev/vdb" },
+		func(string, string) error { return nil },
+	)
+
+	if err := healVolume(&nodeServer{}, "vol-1"); err == nil {
+		t.Fatal("healVolume() expected an error when the disk lookup fails, got nil")
+	}
+}
+
+func TestHealMountPointsRestagesCorruptedMount(t *testing.T) {
+	// IsLikelyNotMountPoint failing with ESTALE is what getMountState
+	// classifies as corrupted (see mount_utils_test.go).
+	mounter := &fakeMounter{
+		mountRefs:     []string{"/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount"},
+		notMountedErr: syscall.ESTALE,
+	}
+	ns := &nodeServer{k8smounter: mounter}
+
+	if err := ns.healMountPoints("vol-1", "/dev/fake-heal-test"); err != nil {
+		t.Fatalf("healMountPoints() error = %v, want nil", err)
+	}
+	if len(mounter.unmounted) != 1 || mounter.unmounted[0] != "/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount" {
+		t.Fatalf("unmounted = %v, want the single corrupted target unmounted", mounter.unmounted)
+	}
+	if len(mounter.mounted) != 1 || mounter.mounted[0] != "/dev/fake-heal-test:/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount" {
+		t.Fatalf("mounted = %v, want device re-bound to the same target", mounter.mounted)
+	}
+}
+
+func TestHealMountPointsLeavesHealthyMountAlone(t *testing.T) {
+	mounter := &fakeMounter{
+		mountRefs:  []string{"/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount"},
+		notMounted: false,
+	}
+	ns := &nodeServer{k8smounter: mounter}
+
+	if err := ns.healMountPoints("vol-1", "/dev/fake-heal-test"); err != nil {
+		t.Fatalf("healMountPoints() error = %v, want nil", err)
+	}
+	if len(mounter.unmounted) != 0 || len(mounter.mounted) != 0 {
+		t.Fatalf("healMountPoints() touched a healthy mount: unmounted=%v mounted=%v", mounter.unmounted, mounter.mounted)
+	}
+}
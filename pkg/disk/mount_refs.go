@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// getDeviceMountRefs returns every mount point currently backed by device,
+// so NodeUnstageVolume can tell whether the disk is still bind-mounted
+// somewhere else (a leftover publish, the ACK alternate kubelet data-disk
+// path, a raw-block publish target) before detaching it.
+func (ns *nodeServer) getDeviceMountRefs(device string) ([]string, error) {
+	refSet := make(map[string]bool)
+	if refs, err := ns.k8smounter.GetMountRefs(device); err == nil {
+		for _, ref := range refs {
+			refSet[ref] = true
+		}
+	}
+
+	// GetMountRefs above only resolves refs it can reach from a mount path
+	// it recognizes; fall back to a direct mountinfo scan for the device so
+	// refs under alternate prefixes (e.g. ACK's kubelet data-disk mount)
+	// aren't missed.
+	data, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return setToSlice(refSet), nil
+	}
+	for _, ref := range mountInfoRefsForDevice(string(data), device) {
+		refSet[ref] = true
+	}
+	return setToSlice(refSet), nil
+}
+
+// mountInfoRefsForDevice returns the mount points in mountinfo (the contents
+// of /proc/<pid>/mountinfo) whose "mount source" field equals device. Each
+// mountinfo line is "... optional-fields - fstype source super-options"; the
+// source is the second field after the "-" separator, right after fstype.
+func mountInfoRefsForDevice(mountinfo, device string) []string {
+	var refs []string
+	for _, line := range strings.Split(mountinfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		sepIndex := -1
+		for i, f := range fields {
+			if f == "-" {
+				sepIndex = i
+				break
+			}
+		}
+		if sepIndex < 0 || sepIndex+2 >= len(fields) {
+			continue
+		}
+		if fields[sepIndex+2] == device {
+			refs = append(refs, fields[4])
+		}
+	}
+	return refs
+}
+
+func setToSlice(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	return out
+}
+
+// waitForDiskAvailable polls DescribeDisks until volumeID's ECS disk reports
+// Available, so NodeUnstageVolume doesn't return before the API has caught
+// up with the detach, matching the stage/publish/unpublish/unstage
+// sequencing other CSI drivers rely on.
+func waitForDiskAvailable(volumeID string) error {
+	return wait.PollImmediate(2*time.Second, 30*time.Second, func() (bool, error) {
+		disk, err := findDiskByVolumeID(volumeID)
+		if err != nil {
+			return false, nil
+		}
+		if disk == nil {
+			return true, nil
+		}
+		return disk.Status == DiskStatusAvailable, nil
+	})
+}
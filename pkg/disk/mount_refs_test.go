@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMountInfoRefsForDevice(t *testing.T) {
+	// A trimmed /proc/self/mountinfo excerpt: two binds of /dev/vdb (one
+	// under the usual kubelet pod dir, one under ACK's alternate data-disk
+	// path), plus an unrelated entry that must not match.
+	mountinfo := `355 304 253:16 / /var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount rw,relatime shared:100 - ext4 /dev/vdb rw
+356 304 253:16 / /var/lib/container/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount rw,relatime shared:101 - ext4 /dev/vdb rw
+357 304 253:0 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+`
+
+	got := mountInfoRefsForDevice(mountinfo, "/dev/vdb")
+	sort.Strings(got)
+	want := []string{
+		"/var/lib/container/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount",
+		"/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/pv/mount",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("mountInfoRefsForDevice() = %v, want %v", got, want)
+	}
+}
+
+func TestMountInfoRefsForDeviceNoMatch(t *testing.T) {
+	mountinfo := `357 304 253:0 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+`
+	if got := mountInfoRefsForDevice(mountinfo, "/dev/vdb"); len(got) != 0 {
+		t.Fatalf("mountInfoRefsForDevice() = %v, want none", got)
+	}
+}
+
+func TestMountInfoRefsForDeviceSkipsShortLines(t *testing.T) {
+	if got := mountInfoRefsForDevice("not a valid mountinfo line\n", "/dev/vdb"); len(got) != 0 {
+		t.Fatalf("mountInfoRefsForDevice() = %v, want none for a malformed line", got)
+	}
+}
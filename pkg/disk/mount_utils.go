@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+// mountState is the outcome of probing a path that is supposed to be a mount
+// point: plain "not mounted" has to be told apart from "mounted but broken",
+// since the latter needs an unmount+re-stage, not a fresh mount.
+type mountState int
+
+const (
+	mountStateUnmounted mountState = iota
+	mountStateMounted
+	mountStateCorrupted
+)
+
+// IsCorruptedMountError reports whether err, as returned by stat'ing a mount
+// point, indicates the mount is broken (backing device dropped, stale NFS
+// style handle, ...) rather than just "not mounted" or "doesn't exist".
+func IsCorruptedMountError(err error) bool {
+	if err == nil {
+		return false
+	}
+	underlying := err
+	if pathErr, ok := err.(*os.PathError); ok {
+		underlying = pathErr.Err
+	}
+	switch underlying {
+	case syscall.ESTALE, syscall.ENOTCONN, syscall.EIO, syscall.EACCES:
+		return true
+	}
+	return false
+}
+
+// getMountState classifies path as unmounted, mounted or corrupted so
+// callers can react to a broken mount instead of looping on an opaque error.
+func (ns *nodeServer) getMountState(path string) (mountState, error) {
+	if _, err := os.Stat(path); IsCorruptedMountError(err) {
+		return mountStateCorrupted, nil
+	}
+
+	notMounted, err := ns.k8smounter.IsLikelyNotMountPoint(path)
+	if err != nil {
+		if IsCorruptedMountError(err) {
+			return mountStateCorrupted, nil
+		}
+		if os.IsNotExist(err) {
+			return mountStateUnmounted, nil
+		}
+		return mountStateUnmounted, err
+	}
+	if notMounted {
+		return mountStateUnmounted, nil
+	}
+	return mountStateMounted, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	k8smount "k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestIsCorruptedMountError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain ENOENT", syscall.ENOENT, false},
+		{"wrapped ENOENT", &os.PathError{Op: "stat", Path: "/mnt/x", Err: syscall.ENOENT}, false},
+		{"unrelated error", errors.New("boom"), false},
+		{"bare ESTALE", syscall.ESTALE, true},
+		{"wrapped ESTALE", &os.PathError{Op: "stat", Path: "/mnt/x", Err: syscall.ESTALE}, true},
+		{"wrapped ENOTCONN", &os.PathError{Op: "stat", Path: "/mnt/x", Err: syscall.ENOTCONN}, true},
+		{"wrapped EIO", &os.PathError{Op: "stat", Path: "/mnt/x", Err: syscall.EIO}, true},
+		{"wrapped EACCES", &os.PathError{Op: "stat", Path: "/mnt/x", Err: syscall.EACCES}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := IsCorruptedMountError(c.err); got != c.want {
+				t.Errorf("IsCorruptedMountError(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+// fakeMounter is a minimal k8smount.Interface stand-in so getMountState and
+// healMountPoints can be driven without a real bind mount.
+type fakeMounter struct {
+	notMounted    bool
+	notMountedErr error
+
+	mountRefs    []string
+	mountRefsErr error
+	mountErr     error
+	unmountErr   error
+
+	mounted   []string // source+":"+target for every successful Mount
+	unmounted []string // every successful Unmount target
+}
+
+func (f *fakeMounter) Mount(source, target, fstype string, options []string) error {
+	if f.mountErr != nil {
+		return f.mountErr
+	}
+	f.mounted = append(f.mounted, source+":"+target)
+	return nil
+}
+func (f *fakeMounter) Unmount(target string) error {
+	if f.unmountErr != nil {
+		return f.unmountErr
+	}
+	f.unmounted = append(f.unmounted, target)
+	return nil
+}
+func (f *fakeMounter) List() ([]k8smount.MountPoint, error) { return nil, nil }
+func (f *fakeMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	return f.notMounted, f.notMountedErr
+}
+func (f *fakeMounter) GetMountRefs(pathname string) ([]string, error) {
+	return f.mountRefs, f.mountRefsErr
+}
+
+func TestGetMountStateNotMounted(t *testing.T) {
+	ns := &nodeServer{k8smounter: &fakeMounter{notMounted: true}}
+
+	state, err := ns.getMountState(t.TempDir())
+	if err != nil {
+		t.Fatalf("getMountState() error = %v", err)
+	}
+	if state != mountStateUnmounted {
+		t.Fatalf("getMountState() = %v, want mountStateUnmounted", state)
+	}
+}
+
+func TestGetMountStateMounted(t *testing.T) {
+	ns := &nodeServer{k8smounter: &fakeMounter{notMounted: false}}
+
+	state, err := ns.getMountState(t.TempDir())
+	if err != nil {
+		t.Fatalf("getMountState() error = %v", err)
+	}
+	if state != mountStateMounted {
+		t.Fatalf("getMountState() = %v, want mountStateMounted", state)
+	}
+}
+
+func TestGetMountStateCorruptedFromIsLikelyNotMountPointError(t *testing.T) {
+	ns := &nodeServer{k8smounter: &fakeMounter{notMountedErr: syscall.ESTALE}}
+
+	state, err := ns.getMountState(t.TempDir())
+	if err != nil {
+		t.Fatalf("getMountState() error = %v", err)
+	}
+	if state != mountStateCorrupted {
+		t.Fatalf("getMountState() = %v, want mountStateCorrupted", state)
+	}
+}
@@ -18,9 +18,13 @@ package disk
 
 import (
 	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/cms"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/kubernetes-sigs/alibaba-cloud-csi-driver/pkg/credentials"
+	"github.com/kubernetes-sigs/alibaba-cloud-csi-driver/pkg/metrics/sink"
 	"github.com/kubernetes-sigs/alibaba-cloud-csi-driver/pkg/utils"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
@@ -32,6 +36,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
 )
 
 type nodeServer struct {
@@ -41,6 +49,14 @@ type nodeServer struct {
 	tagDisk           string
 	mounter           utils.Mounter
 	k8smounter        k8smount.Interface
+	volumeLocks       *VolumeLocks
+	attachWorkers     chan struct{}
+	metricsSink       sink.MetricsSink
+	// volumeContexts caches each staged volume's VolumeContext (volumeID ->
+	// context), so NodeGetVolumeStats can label pushed metrics with the PV/PVC
+	// identity kubelet set at stage time; NodeGetVolumeStatsRequest itself
+	// carries no VolumeContext.
+	volumeContexts sync.Map
 	*csicommon.DefaultNodeServer
 }
 
@@ -105,7 +121,16 @@ func NewNodeServer(d *csicommon.CSIDriver, c *ecs.Client) csi.NodeServer {
 	os.MkdirAll(VolumeDir, os.FileMode(0755))
 	os.MkdirAll(VolumeDirRemove, os.FileMode(0755))
 
-	return &nodeServer{
+	// attachWorkerPoolSize bounds the attachWorkers channel's buffer.
+	// maxVolumesNum may validly be 0 (see MaxVolumesPerNode below), but a
+	// zero-size channel would make every send to attachWorkers block
+	// forever, so the pool itself is never sized below 1.
+	attachWorkerPoolSize := maxVolumesNum
+	if attachWorkerPoolSize <= 0 {
+		attachWorkerPoolSize = 1
+	}
+
+	ns := &nodeServer{
 		zone:              doc.ZoneID,
 		maxVolumesPerNode: maxVolumesNum,
 		nodeID:            doc.InstanceID,
@@ -113,7 +138,70 @@ func NewNodeServer(d *csicommon.CSIDriver, c *ecs.Client) csi.NodeServer {
 		mounter:           utils.NewMounter(),
 		k8smounter:        k8smount.New(""),
 		tagDisk:           strings.ToLower(tagDiskConf),
+		volumeLocks:       NewVolumeLocks(),
+		// bounds how many ECS AttachDisk calls this node has in flight at
+		// once, independent of how many volumes are staging concurrently
+		attachWorkers: make(chan struct{}, attachWorkerPoolSize),
+		metricsSink:   newMetricsSink(),
 	}
+
+	// Resurrect any volume that was already staged before this plugin
+	// container restarted, instead of waiting for kubelet to notice.
+	go healVolumes(ns)
+
+	return ns
+}
+
+// newMetricsSink builds the optional background exporter that republishes
+// the usage NodeGetVolumeStats already reports to kubelet as custom metrics
+// in Alibaba CloudMonitor (CMS). It is a NoopSink unless CMS_METRICS_ENABLE
+// is set, so the default deployment is unaffected.
+//
+// The CMS client is built from the shared credentials chain rather than raw
+// AK/SK env vars, so it works the same way whether this node authenticates
+// with a static AK/SK, an ECS instance RAM role, or ACK workload identity.
+func newMetricsSink() sink.MetricsSink {
+	if strings.ToLower(os.Getenv("CMS_METRICS_ENABLE")) != "true" {
+		return sink.NoopSink{}
+	}
+	regionID := os.Getenv("REGION_ID")
+	chain, err := credentials.Global(credentials.Options{
+		AccessKeyFile:   os.Getenv("ACCESS_KEY_FILE"),
+		RoleName:        os.Getenv("ROLE_NAME"),
+		OIDCTokenFile:   os.Getenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE"),
+		RoleARN:         os.Getenv("ALIBABA_CLOUD_ROLE_ARN"),
+		OIDCProviderARN: os.Getenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN"),
+	})
+	if err != nil {
+		log.Errorf("NewNodeServer: build credentials chain failed, volume metrics push disabled: %v", err)
+		return sink.NoopSink{}
+	}
+	cred, err := chain.Get()
+	if err != nil {
+		log.Errorf("NewNodeServer: resolve credentials failed, volume metrics push disabled: %v", err)
+		return sink.NoopSink{}
+	}
+	client, err := cms.NewClientWithOptions(regionID, sdk.NewConfig(), cred)
+	if err != nil {
+		log.Errorf("NewNodeServer: create cms client failed, volume metrics push disabled: %v", err)
+		return sink.NoopSink{}
+	}
+
+	interval := 60 * time.Second
+	if value := os.Getenv("CMS_PUSH_INTERVAL_SECONDS"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return sink.NewCMSSink(client, sink.CMSConfig{
+		RegionID:     regionID,
+		Namespace:    os.Getenv("CMS_NAMESPACE"),
+		GroupID:      os.Getenv("CMS_GROUP_ID"),
+		ClusterID:    os.Getenv("CLUSTER_ID"),
+		NodeName:     os.Getenv("KUBE_NODE_NAME"),
+		PushInterval: interval,
+	})
 }
 
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -139,9 +227,21 @@ func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 			},
 		},
 	}
+	nscap4 := &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{
+				Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+			},
+		},
+	}
+	// Inline ephemeral volume support (NodePublishVolume, see ephemeral.go)
+	// is intentionally not advertised here: the CSI spec has no
+	// NodeServiceCapability RPC type for it. Ephemeral is advertised via the
+	// CSIDriver object's spec.volumeLifecycleModes field, set in the
+	// driver's deployment manifest, not returned by this RPC.
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
-			nscap, nscap2, nscap3,
+			nscap, nscap2, nscap3, nscap4,
 		},
 	}, nil
 }
@@ -159,6 +259,25 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume ID must be provided")
 	}
+	// kubelet never calls NodeStageVolume for inline ephemeral volumes (they
+	// are staged internally by createEphemeralVolume), so this has to be
+	// handled before the StagingTargetPath check below
+	if isEphemeralVolume(req.GetVolumeContext()) {
+		log.Infof("NodePublishVolume: provisioning inline ephemeral volume %s, target %s", req.VolumeId, targetPath)
+		stagingPath, err := ns.createEphemeralVolume(req)
+		if err != nil {
+			log.Errorf("NodePublishVolume: create ephemeral volume %s error: %v", req.VolumeId, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := ns.mounter.EnsureFolder(targetPath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := ns.k8smounter.Mount(stagingPath, targetPath, "", []string{"bind"}); err != nil {
+			return nil, status.Error(codes.Internal, "NodePublishVolume: bind mount ephemeral volume error: "+err.Error())
+		}
+		log.Infof("NodePublishVolume: ephemeral volume %s mounted to %s", req.VolumeId, targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
 	if req.StagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Staging Target Path must be provided")
 	}
@@ -183,11 +302,18 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		if err := ns.mounter.EnsureFolder(targetPath); err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-		notmounted, err := ns.k8smounter.IsLikelyNotMountPoint(targetPath)
+		state, err := ns.getMountState(targetPath)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-		if !notmounted {
+		if state == mountStateCorrupted {
+			log.Errorf("NodePublishVolume: VolumeId: %s, targetPath %s is corrupted, unmounting before remount", req.VolumeId, targetPath)
+			if err := ns.k8smounter.Unmount(targetPath); err != nil {
+				return nil, status.Errorf(codes.Internal, "NodePublishVolume: unmount corrupted targetPath %s error: %v", targetPath, err)
+			}
+			state = mountStateUnmounted
+		}
+		if state == mountStateMounted {
 			log.Infof("NodePublishVolume: VolumeId: %s, Path %s is already mounted", req.VolumeId, targetPath)
 		}
 
@@ -253,6 +379,23 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	targetPath := req.GetTargetPath()
 	log.Infof("NodeUnpublishVolume: Starting to Unmount Volume %s, Target %v", req.VolumeId, targetPath)
+
+	// inline ephemeral volumes never get a NodeUnstageVolume call, so they
+	// are torn down (unmount + detach + delete) here instead
+	if getEphemeralDisk(req.VolumeId) != "" {
+		log.Infof("NodeUnpublishVolume: releasing inline ephemeral volume %s", req.VolumeId)
+		if state, err := ns.getMountState(targetPath); err == nil && state != mountStateUnmounted {
+			if err := ns.k8smounter.Unmount(targetPath); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+		if err := ns.deleteEphemeralVolume(req.VolumeId); err != nil {
+			log.Errorf("NodeUnpublishVolume: release ephemeral volume %s error: %v", req.VolumeId, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
 	// Step 1: check folder exists
 	if !IsFileExisting(targetPath) {
 		log.Infof("NodeUnpublishVolume: Volume %s folder %s doesn't exist", req.VolumeId, targetPath)
@@ -260,11 +403,18 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	}
 
 	// Step 2: check mount point
-	notmounted, err := ns.k8smounter.IsLikelyNotMountPoint(targetPath)
+	state, err := ns.getMountState(targetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if notmounted {
+	if state == mountStateCorrupted {
+		log.Errorf("NodeUnpublishVolume: VolumeId: %s, targetPath %s is corrupted, force unmounting", req.VolumeId, targetPath)
+		if !utils.Umount(targetPath) {
+			return nil, status.Errorf(codes.Internal, "NodeUnpublishVolume: force umount corrupted targetPath %s failed", targetPath)
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if state == mountStateUnmounted {
 		if empty, _ := IsDirEmpty(targetPath); empty {
 			log.Infof("NodeUnpublishVolume: %s is unmounted", targetPath)
 			return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -327,6 +477,15 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
 
+	// Serialize Stage/Unstage calls for the same volume; independent volumes
+	// are free to proceed in parallel.
+	if !ns.volumeLocks.TryAcquire(req.VolumeId) {
+		log.Errorf("NodeStageVolume: Previous Stage/Unstage action on volume %s is still in process", req.VolumeId)
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsErrorMsg, req.VolumeId)
+	}
+	defer ns.volumeLocks.Release(req.VolumeId)
+	ns.volumeContexts.Store(req.VolumeId, req.GetVolumeContext())
+
 	isBlock := req.GetVolumeCapability().GetBlock() != nil
 	if isBlock {
 		targetPath = filepath.Join(targetPath, req.VolumeId)
@@ -344,11 +503,18 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 
 	//Step 2: check target path mounted
-	notmounted, err := ns.k8smounter.IsLikelyNotMountPoint(targetPath)
+	state, err := ns.getMountState(targetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if !notmounted {
+	if state == mountStateCorrupted {
+		log.Errorf("NodeStageVolume: volumeId: %s, targetPath %s is corrupted, unmounting before re-staging", req.VolumeId, targetPath)
+		if err := ns.k8smounter.Unmount(targetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeStageVolume: unmount corrupted targetPath %s error: %v", targetPath, err)
+		}
+		state = mountStateUnmounted
+	}
+	if state == mountStateMounted {
 		deviceName := GetDeviceByMntPoint(targetPath)
 		if err := checkDeviceAvailable(deviceName); err != nil {
 			log.Errorf("NodeStageVolume: %s", err.Error())
@@ -359,11 +525,11 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 
 	// Step 3: double check log pattern, check the path is mounted again
-	notmounted, err = ns.k8smounter.IsLikelyNotMountPoint(targetPath)
+	state, err = ns.getMountState(targetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if !notmounted {
+	if state == mountStateMounted {
 		log.Infof("NodeStageVolume:  check again, volumeId: %s, Path: %s is already mounted, device: %s", req.VolumeId, targetPath, GetDevicePath(targetPath))
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
@@ -385,23 +551,13 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 			return nil, status.Error(codes.Aborted, "NodeStageVolume: ADController Enabled, but device can't be found:"+req.VolumeId)
 		}
 	} else {
-		//NodeStageVolume should be called by sequence
-		//In order no to block to caller, use boolean canAttach to check whether to continue.
-		GlobalConfigVar.AttachMutex.Lock()
-		if !GlobalConfigVar.CanAttach {
-			GlobalConfigVar.AttachMutex.Unlock()
-			log.Errorf("NodeStageVolume: Previous attach action is still in process, VolumeId: %s", req.VolumeId)
-			return nil, status.Error(codes.Aborted, "NodeStageVolume: Previous attach action is still in process")
-		}
-		GlobalConfigVar.CanAttach = false
-		GlobalConfigVar.AttachMutex.Unlock()
-		defer func() {
-			GlobalConfigVar.AttachMutex.Lock()
-			GlobalConfigVar.CanAttach = true
-			GlobalConfigVar.AttachMutex.Unlock()
-		}()
-
+		// Bound the number of ECS AttachDisk calls in flight on this node so
+		// a burst of scheduled pods doesn't hammer the API, while still
+		// letting independent volumes attach in parallel instead of queuing
+		// behind a single global in-flight flag.
+		ns.attachWorkers <- struct{}{}
 		device, err = attachDisk(req.GetVolumeId(), ns.nodeID, isSharedDisk, true)
+		<-ns.attachWorkers
 		if err != nil {
 			log.Errorf("NodeStageVolume: Attach volume: %s with error: %s", req.VolumeId, err.Error())
 			return nil, err
@@ -479,6 +635,14 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "NodeUnstageVolume Staging Target Path must be provided")
 	}
 
+	// Serialize Stage/Unstage calls for the same volume; independent volumes
+	// are free to proceed in parallel.
+	if !ns.volumeLocks.TryAcquire(req.VolumeId) {
+		log.Errorf("NodeUnstageVolume: Previous Stage/Unstage action on volume %s is still in process", req.VolumeId)
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsErrorMsg, req.VolumeId)
+	}
+	defer ns.volumeLocks.Release(req.VolumeId)
+
 	// check block device mountpoint
 	targetPath := req.GetStagingTargetPath()
 	tmpPath := filepath.Join(req.GetStagingTargetPath(), req.VolumeId)
@@ -493,15 +657,24 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		}
 	}
 
+	// resolve the backing device before unmounting, so it can still be
+	// looked up to check for other mount refs afterwards
+	device := GetDeviceByMntPoint(targetPath)
+
 	// Step 1: check folder exists and umount
 	msgLog := ""
 	if IsFileExisting(targetPath) {
-		notmounted, err := ns.k8smounter.IsLikelyNotMountPoint(targetPath)
+		state, err := ns.getMountState(targetPath)
 		if err != nil {
 			log.Errorf("NodeUnstageVolume: VolumeId: %s, check mountPoint: %s mountpoint error: %v", req.VolumeId, targetPath, err)
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-		if !notmounted {
+		if state == mountStateCorrupted {
+			log.Errorf("NodeUnstageVolume: VolumeId: %s, targetPath %s is corrupted, force unmounting", req.VolumeId, targetPath)
+			if !utils.Umount(targetPath) {
+				return nil, status.Errorf(codes.Internal, "NodeUnstageVolume: force umount corrupted targetPath %s failed", targetPath)
+			}
+		} else if state == mountStateMounted {
 			err = ns.k8smounter.Unmount(targetPath)
 			if err != nil {
 				log.Errorf("NodeUnstageVolume: VolumeId: %s, umount path: %s failed with: %v", req.VolumeId, targetPath, err)
@@ -528,13 +701,28 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 
 	// Do detach if ADController disable
 	if !GlobalConfigVar.ADControllerEn {
+		if device != "" {
+			refs, err := ns.getDeviceMountRefs(device)
+			if err != nil {
+				log.Warnf("NodeUnstageVolume: VolumeId: %s, list mount refs of device %s error: %v", req.VolumeId, device, err)
+			}
+			if len(refs) > 0 {
+				log.Infof("NodeUnstageVolume: VolumeId: %s, device %s still referenced by %v, skipping detach", req.VolumeId, device, refs)
+				return &csi.NodeUnstageVolumeResponse{}, nil
+			}
+		}
+
 		err := detachDisk(req.VolumeId, ns.nodeID, true)
 		if err != nil {
 			log.Errorf("NodeUnstageVolume: VolumeId: %s, Detach failed with error %v", req.VolumeId, err.Error())
 			return nil, err
 		}
+		if err := waitForDiskAvailable(req.VolumeId); err != nil {
+			log.Warnf("NodeUnstageVolume: VolumeId: %s, disk did not turn Available after detach: %v", req.VolumeId, err)
+		}
 		removeVolumeConfig(req.VolumeId)
 	}
+	ns.volumeContexts.Delete(req.VolumeId)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -587,7 +775,9 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	return &csi.NodeExpandVolumeResponse{}, nil
 }
 
-// NodeGetVolumeStats used for csi metrics
+// NodeGetVolumeStats used for csi metrics, reports CSI VolumeUsage for both
+// filesystem and block volumes plus a VolumeCondition so that the CSI
+// external-health-monitor can surface abnormal volumes.
 func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	var err error
 	targetPath := req.GetVolumePath()
@@ -596,5 +786,139 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	return utils.GetMetrics(targetPath)
+	condition := ns.checkVolumeCondition(req.GetVolumeId(), targetPath)
+
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &csi.NodeGetVolumeStatsResponse{VolumeCondition: condition}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: lstat %s error: %v", targetPath, err)
+	}
+
+	if (info.Mode() & os.ModeDevice) != 0 {
+		size, err := getBlockDeviceSize(targetPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: get size of block device %s error: %v", targetPath, err)
+		}
+		resp := &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Unit:  csi.VolumeUsage_BYTES,
+					Total: size,
+				},
+			},
+			VolumeCondition: condition,
+		}
+		ns.pushVolumeMetric(req.GetVolumeId(), resp)
+		return resp, nil
+	}
+
+	resp, err := utils.GetMetrics(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	resp.VolumeCondition = condition
+	ns.pushVolumeMetric(req.GetVolumeId(), resp)
+	return resp, nil
 }
+
+// csiPV/PVC context keys set by the external-provisioner when
+// --extra-create-metadata is enabled; used here only to label metrics
+// pushed to the optional CloudMonitor sink.
+const (
+	csiPVNameKey       = "csi.storage.k8s.io/pv/name"
+	csiPVCNameKey      = "csi.storage.k8s.io/pvc/name"
+	csiPVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+)
+
+// pushVolumeMetric republishes a NodeGetVolumeStats result to the configured
+// metrics sink. This never blocks or fails the RPC: the sink itself is
+// responsible for dropping samples under back-pressure.
+func (ns *nodeServer) pushVolumeMetric(volumeID string, resp *csi.NodeGetVolumeStatsResponse) {
+	if ns.metricsSink == nil {
+		return
+	}
+	var volCtx map[string]string
+	if value, ok := ns.volumeContexts.Load(volumeID); ok {
+		volCtx, _ = value.(map[string]string)
+	}
+
+	m := sink.VolumeMetric{
+		PVName:       volCtx[csiPVNameKey],
+		PVCName:      volCtx[csiPVCNameKey],
+		PVCNamespace: volCtx[csiPVCNamespaceKey],
+	}
+	if m.PVName == "" {
+		m.PVName = volumeID
+	}
+	for _, usage := range resp.Usage {
+		switch usage.Unit {
+		case csi.VolumeUsage_BYTES:
+			m.CapacityBytes = usage.Total
+			m.UsedBytes = usage.Total - usage.Available
+		case csi.VolumeUsage_INODES:
+			m.CapacityInodes = usage.Total
+			m.UsedInodes = usage.Total - usage.Available
+		}
+	}
+	ns.metricsSink.Push(m)
+}
+
+// checkVolumeCondition reports whether volumeID's device/mount is in a
+// healthy state: a missing device, a corrupted mount or an ECS disk that has
+// fallen out of the In_use state are all surfaced as Abnormal so kubelet and
+// the external-health-monitor can react instead of silently serving stale
+// stats.
+func (ns *nodeServer) checkVolumeCondition(volumeID, targetPath string) *csi.VolumeCondition {
+	state, err := ns.getMountState(targetPath)
+	if err != nil {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s: check mount state error: %v", volumeID, err)}
+	}
+	if state == mountStateCorrupted {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s: mount point %s is corrupted", volumeID, targetPath)}
+	}
+	if state == mountStateUnmounted {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s: %s is not mounted", volumeID, targetPath)}
+	}
+
+	if GetDeviceByMntPoint(targetPath) == "" {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s: device not found for mount path %s", volumeID, targetPath)}
+	}
+
+	if volumeID != "" {
+		if disk, err := findDiskByVolumeID(volumeID); err == nil && disk != nil {
+			// DiskStatusDetached never matches a real DescribeDisks response
+			// (ECS reports Available/Detaching after a detach, never
+			// "detached"); compare against In_use directly so a disk that
+			// has actually fallen out of it - detached, mid-detach, whatever
+			// the post-detach status turns out to be - is caught too.
+			if disk.Status != DiskStatusInuse {
+				return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s: ECS disk status is %s, want %s", volumeID, disk.Status, DiskStatusInuse)}
+			}
+		}
+	}
+
+	return &csi.VolumeCondition{Abnormal: false, Message: "volume is normal"}
+}
+
+// getBlockDeviceSize reports a raw block volume's total capacity in bytes
+// via the BLKGETSIZE64 ioctl, since statfs doesn't apply to unformatted
+// block devices.
+func getBlockDeviceSize(devicePath string) (int64, error) {
+	file, err := os.Open(devicePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var size uint64
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int64(size), nil
+}
+
+// blkGetSize64 is the Linux BLKGETSIZE64 ioctl request number.
+const blkGetSize64 = 0x80081272
@@ -0,0 +1,59 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// volumeOperationAlreadyExistsErrorMsg is returned when a Stage/Unstage
+// request arrives for a volumeID that already has one in flight.
+const volumeOperationAlreadyExistsErrorMsg = "an operation with the given volume %s already exists"
+
+// VolumeLocks keeps track of in-flight operations per volume ID, so callers
+// can serialize requests against the same volume while letting requests
+// against different volumes run concurrently.
+type VolumeLocks struct {
+	locks sets.String
+	mux   sync.Mutex
+}
+
+// NewVolumeLocks creates a VolumeLocks.
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{locks: sets.NewString()}
+}
+
+// TryAcquire locks volumeID if it isn't already locked and returns true, or
+// returns false if another operation already holds it.
+func (vl *VolumeLocks) TryAcquire(volumeID string) bool {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	if vl.locks.Has(volumeID) {
+		return false
+	}
+	vl.locks.Insert(volumeID)
+	return true
+}
+
+// Release unlocks volumeID.
+func (vl *VolumeLocks) Release(volumeID string) {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	vl.locks.Delete(volumeID)
+}
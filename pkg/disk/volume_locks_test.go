@@ -0,0 +1,45 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import "testing"
+
+func TestVolumeLocksTryAcquireRejectsConcurrentHolder(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	if !vl.TryAcquire("vol-1") {
+		t.Fatalf("TryAcquire(vol-1) = false on first call, want true")
+	}
+	if vl.TryAcquire("vol-1") {
+		t.Fatalf("TryAcquire(vol-1) = true while already held, want false")
+	}
+	if !vl.TryAcquire("vol-2") {
+		t.Fatalf("TryAcquire(vol-2) = false, want true: a different volume must not be blocked")
+	}
+}
+
+func TestVolumeLocksReleaseAllowsReacquire(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	if !vl.TryAcquire("vol-1") {
+		t.Fatalf("TryAcquire(vol-1) = false on first call, want true")
+	}
+	vl.Release("vol-1")
+	if !vl.TryAcquire("vol-1") {
+		t.Fatalf("TryAcquire(vol-1) = false after Release, want true")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/cms"
+)
+
+// fakeCMSClient records every PutCustomMetric call instead of talking to a
+// live CloudMonitor endpoint.
+type fakeCMSClient struct {
+	mu    sync.Mutex
+	calls []*cms.PutCustomMetricRequest
+}
+
+func (f *fakeCMSClient) PutCustomMetric(req *cms.PutCustomMetricRequest) (*cms.PutCustomMetricResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, req)
+	return &cms.PutCustomMetricResponse{}, nil
+}
+
+func (f *fakeCMSClient) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func TestCMSSinkFlushesOnBatchSize(t *testing.T) {
+	fake := &fakeCMSClient{}
+	s := NewCMSSink(fake, CMSConfig{
+		RegionID:     "cn-hangzhou",
+		BatchSize:    2,
+		QueueSize:    10,
+		PushInterval: time.Hour,
+	})
+	defer s.Stop()
+
+	s.Push(VolumeMetric{PVName: "pv-a"})
+	s.Push(VolumeMetric{PVName: "pv-b"})
+
+	deadline := time.Now().Add(time.Second)
+	for fake.callCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fake.callCount() != 1 {
+		t.Fatalf("expected 1 PutCustomMetric call once the batch filled, got %d", fake.callCount())
+	}
+}
+
+func TestCMSSinkFlushesOnStop(t *testing.T) {
+	fake := &fakeCMSClient{}
+	s := NewCMSSink(fake, CMSConfig{
+		RegionID:     "cn-hangzhou",
+		BatchSize:    20,
+		QueueSize:    10,
+		PushInterval: time.Hour,
+	})
+
+	s.Push(VolumeMetric{PVName: "pv-a"})
+	s.Stop()
+
+	if fake.callCount() != 1 {
+		t.Fatalf("expected Stop to flush the pending sample, got %d calls", fake.callCount())
+	}
+}
+
+func TestCMSSinkDropsWhenQueueFull(t *testing.T) {
+	fake := &fakeCMSClient{}
+	s := NewCMSSink(fake, CMSConfig{
+		RegionID:     "cn-hangzhou",
+		BatchSize:    1000,
+		QueueSize:    1,
+		PushInterval: time.Hour,
+	})
+	defer s.Stop()
+
+	// The queue only holds one sample; the rest must be dropped rather than
+	// blocking the caller, since Push backs NodeGetVolumeStats.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.Push(VolumeMetric{PVName: "pv-a"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Push blocked instead of dropping samples once the queue filled")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sink implements pluggable publishers for the per-volume usage
+// samples gathered by NodeGetVolumeStats, so operators can see volume fill
+// rates in their monitoring system of choice, in addition to the CSI
+// response returned to kubelet.
+package sink
+
+// VolumeMetric is one point-in-time usage sample for a single volume.
+type VolumeMetric struct {
+	ClusterID      string
+	NodeName       string
+	PVName         string
+	PVCNamespace   string
+	PVCName        string
+	VolumeType     string
+	CapacityBytes  int64
+	UsedBytes      int64
+	CapacityInodes int64
+	UsedInodes     int64
+}
+
+// MetricsSink publishes volume metrics to an external system. Push must
+// never block the caller; a slow or unavailable sink should drop samples
+// instead of blocking NodeGetVolumeStats.
+type MetricsSink interface {
+	// Push enqueues a sample for delivery.
+	Push(metric VolumeMetric)
+	// Stop flushes any buffered samples and releases resources.
+	Stop()
+}
+
+// NoopSink discards every sample. It is the default when no sink is
+// configured.
+type NoopSink struct{}
+
+// Push implements MetricsSink.
+func (NoopSink) Push(VolumeMetric) {}
+
+// Stop implements MetricsSink.
+func (NoopSink) Stop() {}